@@ -0,0 +1,139 @@
+package sonyflake
+
+import (
+	"context"
+	"sync"
+)
+
+// BufferedSonyflake wraps a Sonyflake with a prefetch buffer so that latency-sensitive
+// callers don't have to wait on Sonyflake's instance-wide mutex on every call. One or
+// more producer goroutines keep the buffer topped up by calling the wrapped
+// Sonyflake's NextID in the background; when the buffer runs dry, callers degrade to
+// synchronous generation instead of blocking forever.
+type BufferedSonyflake struct {
+	sf   *Sonyflake
+	ch   chan uint64
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewBufferedSonyflake returns a new BufferedSonyflake wrapping a Sonyflake configured
+// with st, prefetching into a buffer of bufSize IDs filled by producers goroutines.
+// NewBufferedSonyflake returns nil if NewSonyflake(st) would return nil.
+// If bufSize or producers is <= 0, 1 is used instead.
+func NewBufferedSonyflake(st Settings, bufSize int, producers int) *BufferedSonyflake {
+	sf := NewSonyflake(st)
+	if sf == nil {
+		return nil
+	}
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+	if producers <= 0 {
+		producers = 1
+	}
+
+	bsf := &BufferedSonyflake{
+		sf:   sf,
+		ch:   make(chan uint64, bufSize),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < producers; i++ {
+		bsf.wg.Add(1)
+		go bsf.produce()
+	}
+	return bsf
+}
+
+// produce fills bsf.ch until Close is called or the wrapped Sonyflake returns an
+// unrecoverable error (e.g. the Sonyflake time has overflowed).
+func (bsf *BufferedSonyflake) produce() {
+	defer bsf.wg.Done()
+	for {
+		id, err := bsf.sf.NextID()
+		if err != nil {
+			return
+		}
+		select {
+		case bsf.ch <- id:
+		case <-bsf.done:
+			return
+		}
+	}
+}
+
+// NextID returns the next prefetched ID, or falls back to a synchronous
+// bsf.sf.NextID() call if the buffer is currently empty.
+func (bsf *BufferedSonyflake) NextID() (uint64, error) {
+	select {
+	case id := <-bsf.ch:
+		return id, nil
+	default:
+		return bsf.sf.NextID()
+	}
+}
+
+// NextIDs returns n IDs, draining the buffer first and falling back to synchronous
+// generation for whatever the buffer couldn't supply. Since the buffer is filled in
+// issuance order, a run of IDs already sitting in it is typically a whole (or
+// partial) 256-sequence slot from the wrapped Sonyflake.
+func (bsf *BufferedSonyflake) NextIDs(n int) ([]uint64, error) {
+	ids := make([]uint64, 0, n)
+	for len(ids) < n {
+		select {
+		case id := <-bsf.ch:
+			ids = append(ids, id)
+		default:
+			id, err := bsf.sf.NextID()
+			if err != nil {
+				return ids, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Stream returns a channel of IDs that's closed when ctx is done, Close is called, or
+// ID generation fails.
+func (bsf *BufferedSonyflake) Stream(ctx context.Context) <-chan uint64 {
+	out := make(chan uint64)
+	go func() {
+		defer close(out)
+		for {
+			id, err := bsf.NextID()
+			if err != nil {
+				return
+			}
+			select {
+			case out <- id:
+			case <-ctx.Done():
+				return
+			case <-bsf.done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// BufferLen returns the number of IDs currently sitting in the prefetch buffer, for
+// monitoring.
+func (bsf *BufferedSonyflake) BufferLen() int {
+	return len(bsf.ch)
+}
+
+// BufferCap returns the capacity of the prefetch buffer.
+func (bsf *BufferedSonyflake) BufferCap() int {
+	return cap(bsf.ch)
+}
+
+// Close stops all producer goroutines and waits for them to exit. It does not close
+// the channel returned by Stream's callers; those exit on their own once Close fires.
+func (bsf *BufferedSonyflake) Close() {
+	bsf.once.Do(func() {
+		close(bsf.done)
+	})
+	bsf.wg.Wait()
+}
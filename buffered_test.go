@@ -0,0 +1,79 @@
+package sonyflake
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestBufferedSonyflake(t *testing.T, bufSize, producers int) *BufferedSonyflake {
+	t.Helper()
+	bsf := NewBufferedSonyflake(Settings{MachineID: func() (uint16, error) { return 1, nil }}, bufSize, producers)
+	if bsf == nil {
+		t.Fatal("NewBufferedSonyflake() = nil")
+	}
+	t.Cleanup(bsf.Close)
+	return bsf
+}
+
+func TestBufferedSonyflake_NextIDsUnique(t *testing.T) {
+	bsf := newTestBufferedSonyflake(t, 4, 1)
+
+	ids, err := bsf.NextIDs(50)
+	if err != nil {
+		t.Fatalf("NextIDs: %v", err)
+	}
+	if len(ids) != 50 {
+		t.Fatalf("NextIDs(50) returned %d ids", len(ids))
+	}
+	seen := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("NextIDs returned duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestBufferedSonyflake_DegradesToInlineWhenEmpty(t *testing.T) {
+	// A single-slot buffer is drained almost immediately, forcing NextID to fall
+	// back to the synchronous bsf.sf.NextID() path rather than blocking forever.
+	bsf := newTestBufferedSonyflake(t, 1, 1)
+
+	for i := 0; i < 20; i++ {
+		if _, err := bsf.NextID(); err != nil {
+			t.Fatalf("NextID: %v", err)
+		}
+	}
+}
+
+func TestBufferedSonyflake_Stream(t *testing.T) {
+	bsf := newTestBufferedSonyflake(t, 4, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	count := 0
+	for range bsf.Stream(ctx) {
+		count++
+		if count >= 10 {
+			cancel()
+		}
+	}
+	if count < 10 {
+		t.Fatalf("Stream produced only %d ids before ctx cancellation", count)
+	}
+}
+
+func TestBufferedSonyflake_BufferLenAndCap(t *testing.T) {
+	bsf := newTestBufferedSonyflake(t, 8, 1)
+
+	if got := bsf.BufferCap(); got != 8 {
+		t.Fatalf("BufferCap() = %d, want 8", got)
+	}
+	// Give the producer goroutine a moment to fill the buffer.
+	time.Sleep(50 * time.Millisecond)
+	if got := bsf.BufferLen(); got <= 0 {
+		t.Fatalf("BufferLen() = %d, want > 0", got)
+	}
+}
@@ -0,0 +1,227 @@
+package sonyflake
+
+import (
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ID is a generated Sonyflake ID with convenience encodings for the representations
+// callers commonly need at API/storage boundaries (JSON, SQL, URL-safe strings),
+// mirroring the surface of other Snowflake-family ID types.
+//
+// ID's Time, Sequence and Machine methods assume the ID was generated with
+// LayoutSonyflake; for IDs generated with a different Layout (see Settings.Layout),
+// decompose them via that Layout's methods instead.
+type ID uint64
+
+// NextIDTyped generates a next unique ID, same as NextID but returned as an ID.
+// Since ID's Time, Sequence, Machine and Decompose-style accessors hard-code
+// LayoutSonyflake, NextIDTyped refuses to run on a Sonyflake configured with any
+// other Layout (see Settings.Layout) rather than silently returning an ID whose
+// decode methods would report the wrong values.
+func (sf *Sonyflake) NextIDTyped() (ID, error) {
+	if sf.layout != LayoutSonyflake {
+		return 0, fmt.Errorf("sonyflake: NextIDTyped requires LayoutSonyflake; use NextID and Layout.Decompose for a Sonyflake configured with %+v", sf.layout)
+	}
+	id, err := sf.NextID()
+	return ID(id), err
+}
+
+// String returns id as a base-10 string.
+func (id ID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Base2 returns id as a base-2 (binary) string.
+func (id ID) Base2() string {
+	return strconv.FormatUint(uint64(id), 2)
+}
+
+// base32Encoding is unpadded, to keep the common case of a 13-character encoding.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Base32 returns id as a base-32 string, encoding its 8-byte big-endian form.
+func (id ID) Base32() string {
+	return base32Encoding.EncodeToString(id.Bytes())
+}
+
+// base58Alphabet is the Bitcoin base58 alphabet: no 0, O, I or l, to avoid visual
+// ambiguity when an ID is read out loud or copied by hand.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Base58 returns id as a base-58 string.
+func (id ID) Base58() string {
+	if id == 0 {
+		return base58Alphabet[0:1]
+	}
+	var buf [11]byte // ceil(64 / log2(58)) = 11
+	i := len(buf)
+	for n := uint64(id); n > 0; n /= 58 {
+		i--
+		buf[i] = base58Alphabet[n%58]
+	}
+	return string(buf[i:])
+}
+
+// base64Encoding is unpadded and URL-safe.
+var base64Encoding = base64.URLEncoding.WithPadding(base64.NoPadding)
+
+// Base64 returns id as a URL-safe base-64 string, encoding its 8-byte big-endian form.
+func (id ID) Base64() string {
+	return base64Encoding.EncodeToString(id.Bytes())
+}
+
+// Bytes returns id as 8 big-endian bytes.
+func (id ID) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+// Time returns the elapsed time recorded in id, relative to the Settings.StartTime
+// of whichever Sonyflake generated it (see ElapsedTime).
+func (id ID) Time() time.Duration {
+	return LayoutSonyflake.ElapsedTime(uint64(id))
+}
+
+// Sequence returns the sequence number recorded in id (see SequenceNumber).
+func (id ID) Sequence() uint64 {
+	return LayoutSonyflake.SequenceNumber(uint64(id))
+}
+
+// Machine returns the machine id recorded in id (see MachineID).
+func (id ID) Machine() uint64 {
+	return LayoutSonyflake.MachineID(uint64(id))
+}
+
+// ParseString parses a base-10 string, as produced by ID.String, into an ID.
+func ParseString(s string) (ID, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ID(n), nil
+}
+
+// ParseBase2 parses a base-2 string, as produced by ID.Base2, into an ID.
+func ParseBase2(s string) (ID, error) {
+	n, err := strconv.ParseUint(s, 2, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ID(n), nil
+}
+
+// ParseBase32 parses a base-32 string, as produced by ID.Base32, into an ID.
+func ParseBase32(s string) (ID, error) {
+	b, err := base32Encoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return idFromBytes(b)
+}
+
+// ParseBase58 parses a base-58 string, as produced by ID.Base58, into an ID.
+func ParseBase58(s string) (ID, error) {
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		idx := strings.IndexByte(base58Alphabet, s[i])
+		if idx < 0 {
+			return 0, fmt.Errorf("sonyflake: invalid base58 character %q", s[i])
+		}
+		n = n*58 + uint64(idx)
+	}
+	return ID(n), nil
+}
+
+// ParseBase64 parses a URL-safe base-64 string, as produced by ID.Base64, into an ID.
+func ParseBase64(s string) (ID, error) {
+	b, err := base64Encoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return idFromBytes(b)
+}
+
+func idFromBytes(b []byte) (ID, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("sonyflake: want 8 bytes, got %d", len(b))
+	}
+	return ID(binary.BigEndian.Uint64(b)), nil
+}
+
+// MarshalJSON encodes id as a JSON string rather than a JSON number, so that
+// JavaScript consumers (whose numbers only carry 53 bits of integer precision)
+// don't silently truncate it.
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + id.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts either a JSON string or a JSON number, for compatibility
+// with producers that didn't apply MarshalJSON's string-encoding convention.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*id = ID(n)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, returning the same form as String.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *ID) UnmarshalText(data []byte) error {
+	n, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	*id = ID(n)
+	return nil
+}
+
+// Value implements driver.Valuer, so an ID can be passed directly as a query
+// argument to database/sql. IDs always fit in an int64: the layouts in this package
+// reserve at least one high bit, so the value is never negative.
+func (id ID) Value() (driver.Value, error) {
+	return int64(id), nil
+}
+
+// Scan implements sql.Scanner, so an ID can be read directly out of a database/sql
+// row, regardless of whether the driver surfaces the column as an integer, a byte
+// slice or a string.
+func (id *ID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*id = 0
+	case int64:
+		*id = ID(v)
+	case uint64:
+		*id = ID(v)
+	case []byte:
+		n, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		*id = ID(n)
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*id = ID(n)
+	default:
+		return fmt.Errorf("sonyflake: unsupported Scan type %T for ID", src)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package sonyflake
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestID_RoundTrip(t *testing.T) {
+	ids := []ID{0, 1, 255, 65536, 1<<62 - 1, ID(^uint64(0) >> 1)}
+
+	for _, id := range ids {
+		if got, err := ParseString(id.String()); err != nil || got != id {
+			t.Errorf("ParseString(%q) = %v, %v, want %v, nil", id.String(), got, err, id)
+		}
+		if got, err := ParseBase2(id.Base2()); err != nil || got != id {
+			t.Errorf("ParseBase2(%q) = %v, %v, want %v, nil", id.Base2(), got, err, id)
+		}
+		if got, err := ParseBase32(id.Base32()); err != nil || got != id {
+			t.Errorf("ParseBase32(%q) = %v, %v, want %v, nil", id.Base32(), got, err, id)
+		}
+		if got, err := ParseBase58(id.Base58()); err != nil || got != id {
+			t.Errorf("ParseBase58(%q) = %v, %v, want %v, nil", id.Base58(), got, err, id)
+		}
+		if got, err := ParseBase64(id.Base64()); err != nil || got != id {
+			t.Errorf("ParseBase64(%q) = %v, %v, want %v, nil", id.Base64(), got, err, id)
+		}
+		if got, err := idFromBytes(id.Bytes()); err != nil || got != id {
+			t.Errorf("idFromBytes(%v) = %v, %v, want %v, nil", id.Bytes(), got, err, id)
+		}
+	}
+}
+
+func TestID_Base58NoAmbiguousChars(t *testing.T) {
+	for _, c := range base58Alphabet {
+		if c == '0' || c == 'O' || c == 'I' || c == 'l' {
+			t.Fatalf("base58Alphabet contains ambiguous character %q", c)
+		}
+	}
+}
+
+func TestID_JSONRoundTrip(t *testing.T) {
+	id := ID(1<<53 + 1) // beyond JS's 53-bit safe integer range
+
+	b, err := json.Marshal(id)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if string(b) != `"`+id.String()+`"` {
+		t.Fatalf("json.Marshal(%v) = %s, want a quoted string", id, b)
+	}
+
+	var got ID
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", b, err)
+	}
+	if got != id {
+		t.Fatalf("json.Unmarshal(%s) = %v, want %v", b, got, id)
+	}
+
+	// Also accept a bare JSON number, for producers that didn't use MarshalJSON's
+	// string-encoding convention.
+	var got2 ID
+	if err := json.Unmarshal([]byte(id.String()), &got2); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", id.String(), err)
+	}
+	if got2 != id {
+		t.Fatalf("json.Unmarshal(%s) = %v, want %v", id.String(), got2, id)
+	}
+}
+
+func TestID_ValueScanRoundTrip(t *testing.T) {
+	id := ID(123456789)
+
+	v, err := id.Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+
+	var got ID
+	if err := got.Scan(v); err != nil {
+		t.Fatalf("Scan(%v): %v", v, err)
+	}
+	if got != id {
+		t.Fatalf("Scan(%v) = %v, want %v", v, got, id)
+	}
+
+	var gotFromText ID
+	if err := gotFromText.Scan(id.String()); err != nil {
+		t.Fatalf("Scan(%q): %v", id.String(), err)
+	}
+	if gotFromText != id {
+		t.Fatalf("Scan(%q) = %v, want %v", id.String(), gotFromText, id)
+	}
+
+	var gotFromBytes ID
+	if err := gotFromBytes.Scan([]byte(id.String())); err != nil {
+		t.Fatalf("Scan([]byte(%q)): %v", id.String(), err)
+	}
+	if gotFromBytes != id {
+		t.Fatalf("Scan([]byte(%q)) = %v, want %v", id.String(), gotFromBytes, id)
+	}
+}
@@ -0,0 +1,147 @@
+package sonyflake
+
+import (
+	"errors"
+	"time"
+)
+
+// Layout describes how a Sonyflake ID's 64 bits are split between a timestamp, a
+// sequence number and a machine id, and the duration of one timestamp unit. The
+// zero value is not a valid Layout; use one of the Layout* presets or Settings.Layout
+// (which defaults to LayoutSonyflake when left zero).
+type Layout struct {
+	// TimeBits is the number of low-to-high bits used for the timestamp.
+	TimeBits uint8
+	// SequenceBits is the number of bits used for the per-timestamp-unit sequence
+	// number. Must be <= 16.
+	SequenceBits uint8
+	// MachineBits is the number of bits used for the machine id. Must be <= 16.
+	MachineBits uint8
+	// TimeUnit is the duration of one timestamp unit, e.g. 10*time.Millisecond for
+	// the original Sonyflake layout or time.Millisecond for Snowflake compatibility.
+	TimeUnit time.Duration
+	// SignBits is the number of high bits left unused above TimeBits+SequenceBits+
+	// MachineBits (at least 1, to keep generated IDs non-negative when read as an
+	// int64). Defaults to 1 if zero.
+	SignBits uint8
+}
+
+// LayoutSonyflake is the original Sonyflake layout: 39 bits of time in units of
+// 10ms, 8 bits of sequence number, 16 bits of machine id.
+var LayoutSonyflake = Layout{
+	TimeBits:     BitLenTime,
+	SequenceBits: BitLenSequence,
+	MachineBits:  BitLenMachineID,
+	TimeUnit:     10 * time.Millisecond,
+	SignBits:     1,
+}
+
+// LayoutTwitterSnowflake mirrors Twitter's original Snowflake layout: 41 bits of
+// time in units of 1ms, 12 bits of sequence number, 10 bits of machine id.
+var LayoutTwitterSnowflake = Layout{
+	TimeBits:     41,
+	SequenceBits: 12,
+	MachineBits:  10,
+	TimeUnit:     time.Millisecond,
+	SignBits:     1,
+}
+
+// LayoutSonyflakeWithRollback trims two bits off LayoutSonyflake's time field to
+// leave room for a 2-bit rollback/generation indicator, as suggested by some
+// Sonyflake critiques for tagging IDs issued during clock-rollback recovery.
+var LayoutSonyflakeWithRollback = Layout{
+	TimeBits:     BitLenTime - 2,
+	SequenceBits: BitLenSequence,
+	MachineBits:  BitLenMachineID,
+	TimeUnit:     10 * time.Millisecond,
+	SignBits:     3,
+}
+
+// Validate reports whether l is usable: its bit lengths must fit in 64 bits total,
+// its sequence and machine fields must each fit in a uint16, and its time unit must
+// be positive.
+func (l Layout) Validate() error {
+	sign := l.SignBits
+	if sign == 0 {
+		sign = 1
+	}
+	if int(l.TimeBits)+int(l.SequenceBits)+int(l.MachineBits)+int(sign) > 64 {
+		return errors.New("sonyflake: layout bit lengths sum to more than 64 bits")
+	}
+	if l.SequenceBits > 16 || l.MachineBits > 16 {
+		return errors.New("sonyflake: layout sequence and machine bits must each fit in 16 bits")
+	}
+	if l.TimeUnit <= 0 {
+		return errors.New("sonyflake: layout time unit must be positive")
+	}
+	return nil
+}
+
+// maxSequence returns the highest sequence number (inclusive) this layout allows
+// per timestamp unit.
+func (l Layout) maxSequence() uint16 {
+	return uint16(1<<l.SequenceBits - 1)
+}
+
+// toLayoutTime converts t to this layout's timestamp units.
+func (l Layout) toLayoutTime(t time.Time) int64 {
+	return t.UTC().UnixNano() / int64(l.TimeUnit)
+}
+
+// currentElapsedTime returns the elapsed time, in this layout's units, since startTime.
+func (l Layout) currentElapsedTime(startTime int64) int64 {
+	return l.toLayoutTime(time.Now()) - startTime
+}
+
+// sleepDuration returns how long to sleep to reach the given number of elapsed
+// layout time units from now.
+func (l Layout) sleepDuration(overtime int64) time.Duration {
+	unit := int64(l.TimeUnit)
+	return time.Duration(overtime*unit) - time.Duration(time.Now().UTC().UnixNano()%unit)
+}
+
+// elapsedTimeUnits returns the timestamp part of id, in this layout's units.
+func (l Layout) elapsedTimeUnits(id uint64) int64 {
+	return int64(id >> (l.SequenceBits + l.MachineBits))
+}
+
+// ElapsedTime returns the elapsed time when the given ID was generated, assuming it
+// was generated with this layout.
+func (l Layout) ElapsedTime(id uint64) time.Duration {
+	return time.Duration(l.elapsedTimeUnits(id) * int64(l.TimeUnit))
+}
+
+// SequenceNumber returns the sequence number of id, assuming it was generated with
+// this layout.
+func (l Layout) SequenceNumber(id uint64) uint64 {
+	mask := uint64(l.maxSequence()) << l.MachineBits
+	return id & mask >> l.MachineBits
+}
+
+// MachineID returns the machine id of id, assuming it was generated with this layout.
+func (l Layout) MachineID(id uint64) uint64 {
+	mask := uint64(1<<l.MachineBits - 1)
+	return id & mask
+}
+
+// Decompose returns a set of id's parts, assuming it was generated with this layout.
+func (l Layout) Decompose(id uint64) map[string]uint64 {
+	return map[string]uint64{
+		"id":         id,
+		"msb":        id >> 63,
+		"time":       uint64(l.elapsedTimeUnits(id)),
+		"sequence":   l.SequenceNumber(id),
+		"machine-id": l.MachineID(id),
+	}
+}
+
+// toID packs elapsedTime, sequence and machineID into a single uint64 using this
+// layout, returning an error if elapsedTime no longer fits in TimeBits.
+func (l Layout) toID(elapsedTime int64, sequence, machineID uint16) (uint64, error) {
+	if elapsedTime >= 1<<l.TimeBits {
+		return 0, errors.New("over the time limit")
+	}
+	return uint64(elapsedTime)<<(l.SequenceBits+l.MachineBits) |
+		uint64(sequence)<<l.MachineBits |
+		uint64(machineID), nil
+}
@@ -0,0 +1,77 @@
+package sonyflake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLayout_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  Layout
+		wantErr bool
+	}{
+		{"sonyflake", LayoutSonyflake, false},
+		{"twitter snowflake", LayoutTwitterSnowflake, false},
+		{"sonyflake with rollback", LayoutSonyflakeWithRollback, false},
+		{"bit lengths too wide", Layout{TimeBits: 40, SequenceBits: 8, MachineBits: 16, TimeUnit: time.Millisecond, SignBits: 1}, true},
+		{"sequence bits too wide", Layout{TimeBits: 20, SequenceBits: 17, MachineBits: 10, TimeUnit: time.Millisecond, SignBits: 1}, true},
+		{"machine bits too wide", Layout{TimeBits: 20, SequenceBits: 10, MachineBits: 17, TimeUnit: time.Millisecond, SignBits: 1}, true},
+		{"zero time unit", Layout{TimeBits: 39, SequenceBits: 8, MachineBits: 16, SignBits: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.layout.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLayout_Decompose(t *testing.T) {
+	id, err := LayoutTwitterSnowflake.toID(12345, 67, 890)
+	if err != nil {
+		t.Fatalf("toID: %v", err)
+	}
+	if got := LayoutTwitterSnowflake.elapsedTimeUnits(id); got != 12345 {
+		t.Errorf("elapsedTimeUnits() = %d, want 12345", got)
+	}
+	if got := LayoutTwitterSnowflake.SequenceNumber(id); got != 67 {
+		t.Errorf("SequenceNumber() = %d, want 67", got)
+	}
+	if got := LayoutTwitterSnowflake.MachineID(id); got != 890 {
+		t.Errorf("MachineID() = %d, want 890", got)
+	}
+}
+
+func TestNewSonyflake_RejectsOversizedMachineID(t *testing.T) {
+	// LayoutTwitterSnowflake only has 10 MachineBits (max value 1023); 5000 overflows
+	// into the sequence/time bits and must be rejected rather than silently truncated.
+	sf := NewSonyflake(Settings{
+		Layout:    LayoutTwitterSnowflake,
+		MachineID: func() (uint16, error) { return 5000, nil },
+	})
+	if sf != nil {
+		t.Fatal("NewSonyflake() = non-nil, want nil for a machine id that overflows Layout.MachineBits")
+	}
+}
+
+func TestNewSonyflake_AcceptsInRangeMachineID(t *testing.T) {
+	sf := NewSonyflake(Settings{
+		Layout:    LayoutTwitterSnowflake,
+		MachineID: func() (uint16, error) { return 900, nil },
+	})
+	if sf == nil {
+		t.Fatal("NewSonyflake() = nil, want non-nil for a machine id within Layout.MachineBits")
+	}
+
+	id, err := sf.NextID()
+	if err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if got := LayoutTwitterSnowflake.MachineID(id); got != 900 {
+		t.Errorf("MachineID() = %d, want 900", got)
+	}
+}
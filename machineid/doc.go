@@ -0,0 +1,17 @@
+// Package machineid is an umbrella for ready-made Settings.MachineID /
+// Settings.CheckMachineID implementations backed by a shared coordination service,
+// for deployments that run many Sonyflake instances and can't rely on each one
+// deriving a unique machine id from its own private IP.
+//
+// Each subpackage ships an Allocator that leases a uint16 machine id from a
+// particular backend (MySQL, Redis, etcd, or Kubernetes StatefulSet ordinals) and
+// renews that lease on a heartbeat, so a network partition that causes a lease to
+// expire is caught by CheckMachineID rather than silently producing duplicate IDs.
+// Wire one up as:
+//
+//	alloc, err := mysql.NewAllocator(db, mysql.Config{...})
+//	sf := sonyflake.NewSonyflake(sonyflake.Settings{
+//		MachineID:      alloc.MachineID,
+//		CheckMachineID: alloc.CheckMachineID,
+//	})
+package machineid
@@ -0,0 +1,190 @@
+// Package etcd provides an Allocator that uses a shared atomic counter to
+// pick a candidate machine id and acquires a lease-bound key, /sonyflake/workers/<id>
+// by default, for it, retrying with the next candidate until one succeeds; the lease
+// is kept alive via etcd's native lease keep-alive.
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// maxMachineID is the number of machine id slots (0..65535) an Allocator scans.
+const maxMachineID = 1 << 16
+
+// Config configures an Allocator.
+type Config struct {
+	// KeyPrefix namespaces the per-id lease keys. Defaults to "/sonyflake/workers/".
+	KeyPrefix string
+	// LeaseTTL is the etcd lease TTL; etcd's keep-alive loop renews it well before
+	// expiry. Defaults to 10 seconds.
+	LeaseTTL time.Duration
+}
+
+// Allocator acquires a uint16 machine id as a lease-bound etcd key. Its MachineID
+// and CheckMachineID methods satisfy sonyflake.Settings.MachineID and
+// sonyflake.Settings.CheckMachineID.
+type Allocator struct {
+	client  *clientv3.Client
+	cfg     Config
+	leaseID clientv3.LeaseID
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	id      uint16
+}
+
+// NewAllocator grants an etcd lease and claims the first free /sonyflake/workers/<id>
+// key bound to it, then starts etcd's keep-alive loop to renew the lease until Close
+// is called.
+func NewAllocator(ctx context.Context, client *clientv3.Client, cfg Config) (*Allocator, error) {
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "/sonyflake/workers/"
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = 10 * time.Second
+	}
+
+	lease, err := client.Grant(ctx, int64(cfg.LeaseTTL/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("machineid/etcd: grant lease: %w", err)
+	}
+
+	a := &Allocator{client: client, cfg: cfg, leaseID: lease.ID}
+	id, err := a.claimFreeSlot(ctx)
+	if err != nil {
+		client.Revoke(ctx, lease.ID)
+		return nil, fmt.Errorf("machineid/etcd: claim machine id: %w", err)
+	}
+	a.id = id
+
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	keepAlive, err := client.KeepAlive(keepAliveCtx, lease.ID)
+	if err != nil {
+		cancel()
+		client.Revoke(ctx, lease.ID)
+		return nil, fmt.Errorf("machineid/etcd: start keep-alive: %w", err)
+	}
+	go func() {
+		// etcd requires the keep-alive channel to be drained for renewal to happen.
+		for range keepAlive {
+		}
+	}()
+
+	return a, nil
+}
+
+func (a *Allocator) key(id uint16) string {
+	return a.cfg.KeyPrefix + strconv.Itoa(int(id))
+}
+
+// counterKey holds a shared counter used to pick candidate machine ids, so that
+// repeated calls (across processes and across restarts) advance past ids that are
+// already claimed instead of every caller re-scanning from id 0.
+func (a *Allocator) counterKey() string {
+	return a.cfg.KeyPrefix + "counter"
+}
+
+// nextCounter atomically increments counterKey and returns its new value, via a
+// compare-and-swap retry loop on the key's ModRevision (etcd has no native INCR).
+func (a *Allocator) nextCounter(ctx context.Context) (int64, error) {
+	key := a.counterKey()
+	for {
+		resp, err := a.client.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		var cur int64
+		var cmp clientv3.Cmp
+		if len(resp.Kvs) == 0 {
+			cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		} else {
+			cur, err = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		}
+
+		next := cur + 1
+		txn := a.client.Txn(ctx).
+			If(cmp).
+			Then(clientv3.OpPut(key, strconv.FormatInt(next, 10)))
+		tresp, err := txn.Commit()
+		if err != nil {
+			return 0, err
+		}
+		if tresp.Succeeded {
+			return next, nil
+		}
+		// counterKey changed concurrently; retry with its new value.
+	}
+}
+
+// claimFreeSlot atomically claims a free machine id slot. It advances the shared
+// etcd counter to pick each candidate id and claims it with a CreateRevision-guarded
+// transaction, which is what makes the claim atomic across competing instances. It
+// retries with the next candidate if that particular slot is still claimed by
+// someone else.
+func (a *Allocator) claimFreeSlot(ctx context.Context) (uint16, error) {
+	for attempt := 0; attempt < maxMachineID; attempt++ {
+		n, err := a.nextCounter(ctx)
+		if err != nil {
+			return 0, err
+		}
+		id := uint16(uint64(n) % maxMachineID)
+		key := a.key(id)
+		txn := a.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, strconv.FormatInt(int64(a.leaseID), 10), clientv3.WithLease(a.leaseID)))
+		resp, err := txn.Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no free machine id slots")
+}
+
+// MachineID returns the currently leased machine id.
+func (a *Allocator) MachineID() (uint16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.id, nil
+}
+
+// CheckMachineID reports whether id is the one currently leased by this Allocator
+// and that its key is still present and bound to our lease, so a partition that
+// caused the lease to expire is caught instead of risking a duplicate id.
+func (a *Allocator) CheckMachineID(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id != a.id {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := a.client.Get(ctx, a.key(a.id))
+	if err != nil || len(resp.Kvs) != 1 {
+		return false
+	}
+	return resp.Kvs[0].Lease == int64(a.leaseID)
+}
+
+// Close stops the lease keep-alive loop and revokes the lease, releasing the key
+// immediately rather than waiting for it to expire.
+func (a *Allocator) Close() {
+	a.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	a.client.Revoke(ctx, a.leaseID)
+}
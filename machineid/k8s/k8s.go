@@ -0,0 +1,142 @@
+// Package k8s provides an Allocator that derives a Sonyflake machine id
+// from a pod's StatefulSet ordinal (the "-<N>" suffix Kubernetes appends to pod
+// names in a StatefulSet), which is already unique and stable across restarts
+// without needing a separate coordination service.
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var ordinalSuffix = regexp.MustCompile(`-(\d+)$`)
+
+// Config configures an Allocator.
+type Config struct {
+	// Namespace is the pod's namespace. Defaults to $POD_NAMESPACE.
+	Namespace string
+	// PodName is the pod's own name, used to parse the StatefulSet ordinal and to
+	// re-check liveness. Defaults to $POD_NAME, falling back to os.Hostname().
+	PodName string
+	// HeartbeatInterval is how often the pod's liveness is re-checked against the
+	// Kubernetes API in the background. Defaults to 30 seconds.
+	HeartbeatInterval time.Duration
+}
+
+// Allocator derives a uint16 machine id from a pod's StatefulSet ordinal. Its
+// MachineID and CheckMachineID methods satisfy sonyflake.Settings.MachineID and
+// sonyflake.Settings.CheckMachineID.
+type Allocator struct {
+	client kubernetes.Interface
+	cfg    Config
+	id     uint16
+	done   chan struct{}
+
+	mu      sync.Mutex
+	podUID  types.UID
+	healthy bool
+}
+
+// NewAllocator parses the StatefulSet ordinal out of cfg.PodName (or its defaults)
+// and confirms the pod currently exists via client, then starts a background
+// heartbeat that keeps re-confirming it every HeartbeatInterval.
+func NewAllocator(client kubernetes.Interface, cfg Config) (*Allocator, error) {
+	if cfg.PodName == "" {
+		cfg.PodName = os.Getenv("POD_NAME")
+	}
+	if cfg.PodName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("machineid/k8s: determine pod name: %w", err)
+		}
+		cfg.PodName = hostname
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 30 * time.Second
+	}
+
+	m := ordinalSuffix.FindStringSubmatch(cfg.PodName)
+	if m == nil {
+		return nil, fmt.Errorf("machineid/k8s: pod name %q doesn't have a StatefulSet -<ordinal> suffix", cfg.PodName)
+	}
+	ordinal, err := strconv.ParseUint(m[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("machineid/k8s: parse ordinal from pod name %q: %w", cfg.PodName, err)
+	}
+
+	a := &Allocator{client: client, cfg: cfg, id: uint16(ordinal), done: make(chan struct{})}
+	if err := a.refresh(context.Background()); err != nil {
+		return nil, fmt.Errorf("machineid/k8s: look up pod %s/%s: %w", cfg.Namespace, cfg.PodName, err)
+	}
+
+	go a.heartbeat()
+	return a, nil
+}
+
+// refresh re-fetches the pod and records whether it's still present and still the
+// same pod object (same UID) as when the Allocator was created.
+func (a *Allocator) refresh(ctx context.Context) error {
+	pod, err := a.client.CoreV1().Pods(a.cfg.Namespace).Get(ctx, a.cfg.PodName, metav1.GetOptions{})
+	if err != nil {
+		a.mu.Lock()
+		a.healthy = false
+		a.mu.Unlock()
+		return err
+	}
+	a.mu.Lock()
+	if a.podUID != "" && pod.UID != a.podUID {
+		a.healthy = false
+		a.mu.Unlock()
+		return fmt.Errorf("pod %s/%s was replaced: uid %s, was %s", a.cfg.Namespace, a.cfg.PodName, pod.UID, a.podUID)
+	}
+	a.podUID = pod.UID
+	a.healthy = true
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Allocator) heartbeat() {
+	ticker := time.NewTicker(a.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), a.cfg.HeartbeatInterval)
+			a.refresh(ctx)
+			cancel()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// MachineID returns the machine id derived from this pod's StatefulSet ordinal.
+func (a *Allocator) MachineID() (uint16, error) {
+	return a.id, nil
+}
+
+// CheckMachineID reports whether id matches this pod's ordinal and the pod was
+// confirmed present as of the last heartbeat, so a pod whose identity Kubernetes
+// has since reassigned is rejected instead of risking a duplicate id.
+func (a *Allocator) CheckMachineID(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return id == a.id && a.healthy
+}
+
+// Close stops the background heartbeat.
+func (a *Allocator) Close() {
+	close(a.done)
+}
@@ -0,0 +1,219 @@
+// Package mysql provides an Allocator backed by a MySQL worker-lease
+// table, for clusters that already run a shared MySQL instance and want a simple
+// way to hand out unique Sonyflake machine ids without standing up Redis or etcd.
+//
+// It expects a table along the lines of:
+//
+//	CREATE TABLE sonyflake_workers (
+//		id           SMALLINT UNSIGNED NOT NULL PRIMARY KEY,
+//		ip           VARCHAR(45)  NOT NULL,
+//		hostname     VARCHAR(255) NOT NULL,
+//		token        CHAR(32)     NOT NULL,
+//		leased_until DATETIME     NOT NULL
+//	)
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMachineID is the number of machine id slots (0..65535) lease scans.
+const maxMachineID = 1 << 16
+
+// Config configures an Allocator.
+type Config struct {
+	// IP identifies this process in sonyflake_workers.ip. Required.
+	IP string
+	// Hostname identifies this process in sonyflake_workers.hostname. Required.
+	Hostname string
+	// LeaseTTL is how long a lease is valid for before it must be renewed.
+	// Defaults to 1 minute.
+	LeaseTTL time.Duration
+	// CacheFile is where the leased id and this instance's lease token are cached on
+	// local disk, so that a process restart reuses the same machine id instead of
+	// leasing a new row every time. Defaults to a path under os.TempDir() that
+	// includes this process's pid, so that two instances of the same binary started
+	// on the same host never resolve to the same default path. Setting CacheFile
+	// explicitly trades that collision-safety for surviving process restarts under a
+	// fixed path; callers doing so must ensure each colocated instance is given a
+	// distinct CacheFile.
+	CacheFile string
+}
+
+// Allocator leases a uint16 machine id from a MySQL sonyflake_workers table and
+// renews it on a heartbeat. Its MachineID and CheckMachineID methods satisfy
+// sonyflake.Settings.MachineID and sonyflake.Settings.CheckMachineID.
+type Allocator struct {
+	db    *sql.DB
+	cfg   Config
+	mu    sync.Mutex
+	id    uint16
+	token string
+	done  chan struct{}
+}
+
+// NewAllocator leases (or reuses, via the local cache) a machine id using db, and
+// starts a background heartbeat that renews the lease every LeaseTTL/2.
+func NewAllocator(db *sql.DB, cfg Config) (*Allocator, error) {
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = time.Minute
+	}
+	if cfg.CacheFile == "" {
+		cfg.CacheFile = filepath.Join(os.TempDir(), fmt.Sprintf("sonyflake-machine-id.%d", os.Getpid()))
+	}
+
+	a := &Allocator{db: db, cfg: cfg, done: make(chan struct{})}
+
+	if id, token, ok := a.readCache(); ok && a.renew(id, token) {
+		a.id, a.token = id, token
+	} else {
+		token, err := newToken()
+		if err != nil {
+			return nil, fmt.Errorf("machineid/mysql: generate lease token: %w", err)
+		}
+		id, err := a.lease(token)
+		if err != nil {
+			return nil, fmt.Errorf("machineid/mysql: lease machine id: %w", err)
+		}
+		a.id, a.token = id, token
+		a.writeCache(id, token)
+	}
+
+	go a.heartbeat()
+	return a, nil
+}
+
+// newToken generates a random per-instance identifier, stored alongside the leased
+// id both in sonyflake_workers and in CacheFile. Checking it in renew (in addition
+// to ip) keeps two instances that happen to share an IP and, through a misconfigured
+// or shared CacheFile, the same cached id, from silently renewing each other's lease
+// as if it were their own.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lease scans machine id slots in order within a transaction, row-locking each
+// candidate id, and claims the first one that's either unused or whose previous
+// lease has expired. Unlike deriving an id from an ever-growing auto_increment
+// counter, this keeps the live id space bounded to maxMachineID regardless of how
+// many leases have been granted and released over the table's lifetime.
+func (a *Allocator) lease(token string) (uint16, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for id := 0; id < maxMachineID; id++ {
+		var leasedUntil time.Time
+		err := tx.QueryRow("SELECT leased_until FROM sonyflake_workers WHERE id = ? FOR UPDATE", id).Scan(&leasedUntil)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec(
+				"INSERT INTO sonyflake_workers (id, ip, hostname, token, leased_until) VALUES (?, ?, ?, ?, ?)",
+				id, a.cfg.IP, a.cfg.Hostname, token, time.Now().Add(a.cfg.LeaseTTL),
+			); err != nil {
+				return 0, err
+			}
+			return uint16(id), tx.Commit()
+		case err != nil:
+			return 0, err
+		case leasedUntil.Before(time.Now()):
+			if _, err := tx.Exec(
+				"UPDATE sonyflake_workers SET ip = ?, hostname = ?, token = ?, leased_until = ? WHERE id = ?",
+				a.cfg.IP, a.cfg.Hostname, token, time.Now().Add(a.cfg.LeaseTTL), id,
+			); err != nil {
+				return 0, err
+			}
+			return uint16(id), tx.Commit()
+		}
+		// still leased by someone else; try the next id
+	}
+	return 0, errors.New("no free machine id slots")
+}
+
+// renew extends this instance's lease on id, returning false if the row is gone or
+// no longer belongs to this instance (e.g. reclaimed after an expiry, or leased by a
+// different instance that happens to share our IP).
+func (a *Allocator) renew(id uint16, token string) bool {
+	res, err := a.db.Exec(
+		"UPDATE sonyflake_workers SET leased_until = ? WHERE id = ? AND ip = ? AND token = ?",
+		time.Now().Add(a.cfg.LeaseTTL), id, a.cfg.IP, token,
+	)
+	if err != nil {
+		return false
+	}
+	n, err := res.RowsAffected()
+	return err == nil && n == 1
+}
+
+func (a *Allocator) heartbeat() {
+	ticker := time.NewTicker(a.cfg.LeaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			a.renew(a.id, a.token)
+			a.mu.Unlock()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// MachineID returns the currently leased machine id.
+func (a *Allocator) MachineID() (uint16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.id, nil
+}
+
+// CheckMachineID reports whether id is the one currently leased by this Allocator
+// and that the lease is still renewable, so a partitioned instance that can no
+// longer reach MySQL is rejected instead of risking a duplicate id.
+func (a *Allocator) CheckMachineID(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return id == a.id && a.renew(a.id, a.token)
+}
+
+// Close stops the background heartbeat. It does not release the lease; the row
+// will simply expire once leased_until passes.
+func (a *Allocator) Close() {
+	close(a.done)
+}
+
+func (a *Allocator) readCache() (id uint16, token string, ok bool) {
+	b, err := os.ReadFile(a.cfg.CacheFile)
+	if err != nil {
+		return 0, "", false
+	}
+	idStr, token, found := strings.Cut(strings.TrimSpace(string(b)), ":")
+	if !found {
+		return 0, "", false
+	}
+	n, err := strconv.ParseUint(idStr, 10, 16)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint16(n), token, true
+}
+
+func (a *Allocator) writeCache(id uint16, token string) {
+	_ = os.WriteFile(a.cfg.CacheFile, []byte(strconv.Itoa(int(id))+":"+token), 0o600)
+}
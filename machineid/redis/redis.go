@@ -0,0 +1,141 @@
+// Package redis provides an Allocator that leases a free machine id slot
+// from a Redis instance, using a shared atomic counter to pick a candidate id and a
+// SETNX-guarded key per id so the lease acquisition is atomic across competing
+// instances.
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxMachineID is the number of machine id slots (0..65535) an Allocator scans.
+const maxMachineID = 1 << 16
+
+// Config configures an Allocator.
+type Config struct {
+	// KeyPrefix namespaces the per-id lease keys. Defaults to "sonyflake:machineid:".
+	KeyPrefix string
+	// LeaseTTL is how long a lease is held before it must be renewed. Defaults to 1 minute.
+	LeaseTTL time.Duration
+}
+
+// Allocator leases a uint16 machine id from Redis and renews it on a heartbeat.
+// Its MachineID and CheckMachineID methods satisfy sonyflake.Settings.MachineID and
+// sonyflake.Settings.CheckMachineID.
+type Allocator struct {
+	client *redis.Client
+	cfg    Config
+	mu     sync.Mutex
+	id     uint16
+	done   chan struct{}
+}
+
+// NewAllocator leases a free machine id slot via client, and starts a background
+// heartbeat that renews the lease every LeaseTTL/2. The slot is the first free one
+// in shared-counter order (see leaseFreeSlot), not necessarily the smallest free id.
+func NewAllocator(ctx context.Context, client *redis.Client, cfg Config) (*Allocator, error) {
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "sonyflake:machineid:"
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = time.Minute
+	}
+
+	a := &Allocator{client: client, cfg: cfg, done: make(chan struct{})}
+	id, err := a.leaseFreeSlot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("machineid/redis: lease machine id: %w", err)
+	}
+	a.id = id
+
+	go a.heartbeat()
+	return a, nil
+}
+
+func (a *Allocator) key(id uint16) string {
+	return fmt.Sprintf("%s%d", a.cfg.KeyPrefix, id)
+}
+
+// counterKey holds a shared counter used to pick candidate machine ids, so that
+// repeated calls (across processes and across restarts) advance past ids that are
+// already leased instead of every caller re-scanning from id 0.
+func (a *Allocator) counterKey() string {
+	return a.cfg.KeyPrefix + "next"
+}
+
+// leaseFreeSlot atomically claims a free machine id slot. It advances a shared
+// Redis counter to pick each candidate id (INCR is O(1) and, because the counter
+// persists in Redis, skips over ids already claimed by earlier callers instead of
+// re-scanning the whole id space from 0 every time) and claims it with SETNX, which
+// is what makes the claim atomic across competing instances. It retries with the
+// next candidate if that particular slot is still leased by someone else.
+func (a *Allocator) leaseFreeSlot(ctx context.Context) (uint16, error) {
+	for attempt := 0; attempt < maxMachineID; attempt++ {
+		n, err := a.client.Incr(ctx, a.counterKey()).Result()
+		if err != nil {
+			return 0, err
+		}
+		id := uint16(uint64(n) % maxMachineID)
+		ok, err := a.client.SetNX(ctx, a.key(id), "1", a.cfg.LeaseTTL).Result()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no free machine id slots")
+}
+
+func (a *Allocator) heartbeat() {
+	ticker := time.NewTicker(a.cfg.LeaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), a.cfg.LeaseTTL/2)
+			a.mu.Lock()
+			a.client.Expire(ctx, a.key(a.id), a.cfg.LeaseTTL)
+			a.mu.Unlock()
+			cancel()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// MachineID returns the currently leased machine id.
+func (a *Allocator) MachineID() (uint16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.id, nil
+}
+
+// CheckMachineID reports whether id is the one currently leased by this Allocator
+// and that its Redis lease key is still renewable, so a partitioned instance that
+// can no longer reach Redis is rejected instead of risking a duplicate id.
+func (a *Allocator) CheckMachineID(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id != a.id {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ok, err := a.client.Expire(ctx, a.key(a.id), a.cfg.LeaseTTL).Result()
+	return err == nil && ok
+}
+
+// Close stops the background heartbeat and releases the lease key.
+func (a *Allocator) Close() {
+	close(a.done)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	a.client.Del(ctx, a.key(a.id))
+}
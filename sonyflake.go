@@ -1,10 +1,12 @@
 // Package sonyflake implements Sonyflake, a distributed unique ID generator inspired by Twitter's Snowflake.
 //
-// A Sonyflake ID is composed of
+// By default, a Sonyflake ID is composed of
 //
 //	39 bits for time in units of 10 msec
 //	 8 bits for a sequence number
 //	16 bits for a machine id
+//
+// This bit layout can be customized via Settings.Layout; see Layout and its presets.
 package sonyflake
 
 import (
@@ -38,6 +40,12 @@ const (
 // CheckMachineID validates the uniqueness of the machine ID.
 // If CheckMachineID returns false, Sonyflake is not created.
 // If CheckMachineID is nil, no validation is done.
+// CheckMachineID is also re-run by NextID at the start of every new time slot, so a
+// lease-based MachineID (see the machineid subpackage) whose lease has silently
+// expired is caught rather than continuing to mint IDs under it.
+//
+// ClockRollbackPolicy configures how NextID reacts to a wall clock rollback.
+// See ClockRollbackPolicy for its zero-value defaults.
 type Settings struct {
 	// StartTime 开始时间
 	StartTime time.Time
@@ -47,6 +55,72 @@ type Settings struct {
 	// CheckMachineID 方法通常可以配合 MachineID 一起使用，先 MachineID 去中心化服务上获取 ID（例如 Redis 集群，或者本地生产），
 	// 然后利用 CheckMachineID 去中心化的服务中检查此输入的 uint16 是否是真的唯一
 	CheckMachineID func(uint16) bool
+	// ClockRollbackPolicy 时间回拨策略，零值时使用默认阈值（见 ClockRollbackPolicy 注释）
+	ClockRollbackPolicy ClockRollbackPolicy
+	// Layout 控制 ID 各部分的位宽与时间单位，零值时使用 LayoutSonyflake（见 Layout 注释）
+	Layout Layout
+}
+
+// ClockRollbackPolicy controls how Sonyflake.NextID behaves when the wall clock is
+// observed to have moved backwards relative to the last issued ID, instead of always
+// sleeping under the instance-wide lock until the clock catches up.
+//
+// Rollbacks are classified by their size, measured in Sonyflake time units (10ms each
+// for the default layout):
+//
+//   - <= SmallRollbackUnits: recovered in-memory from a ring buffer of recently issued
+//     sequences, without sleeping.
+//   - SmallRollbackUnits < delta <= LargeRollbackUnits: the previous sleep-and-retry
+//     behavior, except the mutex is released while sleeping.
+//   - > LargeRollbackUnits: OnClockRollback is invoked so the caller can fail the
+//     instance over, then NextID falls back to sleep-and-retry.
+type ClockRollbackPolicy struct {
+	// SmallRollbackUnits is the largest rollback recovered from the in-memory ring
+	// buffer instead of sleeping. Defaults to 1 if zero.
+	SmallRollbackUnits int64
+	// LargeRollbackUnits is the rollback size beyond which OnClockRollback is invoked.
+	// Defaults to 500 if zero.
+	LargeRollbackUnits int64
+	// OnSequenceExhausted is called when a past time slot's 256 sequence numbers have
+	// all been reused while recovering from a small rollback. It should return a
+	// sequence number leased from a cross-instance source (e.g. a shared counter); if
+	// it returns an error, NextID returns that error. If nil, NextID instead falls
+	// back to the sleep-and-retry path.
+	//
+	// NextID invokes OnSequenceExhausted with the Sonyflake's internal mutex released,
+	// so it is safe for the hook to call back into the same Sonyflake (e.g. Stats or
+	// NextID) without deadlocking.
+	OnSequenceExhausted func(elapsedTime int64) (uint16, error)
+	// OnClockRollback is invoked for rollbacks larger than LargeRollbackUnits so the
+	// caller can mark the instance unhealthy or route traffic elsewhere. If it
+	// returns an error, NextID returns that error instead of sleeping.
+	//
+	// NextID invokes OnClockRollback with the Sonyflake's internal mutex released, so
+	// it is safe for the hook to call back into the same Sonyflake (e.g. Stats or
+	// NextID) without deadlocking.
+	OnClockRollback func(delta time.Duration) error
+}
+
+// clockRollbackRingSize is the number of past time slots whose highest issued
+// sequence number is remembered for small-rollback recovery.
+const clockRollbackRingSize = 128
+
+// rollbackSlot remembers the highest sequence number issued for a given elapsedTime,
+// so that a small clock rollback can resume from it instead of sleeping.
+type rollbackSlot struct {
+	elapsedTime int64
+	sequence    uint16
+}
+
+// Stats reports Sonyflake's clock-rollback handling counters since creation.
+type Stats struct {
+	// Rollbacks is the number of times NextID observed the wall clock moving backwards.
+	Rollbacks uint64
+	// Waits is the number of times NextID slept for a sequence-slot or clock rollback.
+	Waits uint64
+	// Exhaustions is the number of times a past slot's ring-buffered sequence was
+	// exhausted and OnSequenceExhausted was consulted.
+	Exhaustions uint64
 }
 
 // Sonyflake is a distributed unique ID generator.
@@ -56,25 +130,45 @@ type Sonyflake struct {
 	elapsedTime int64  // 已经过去的时间，用于检查是否有时间回拨现象
 	sequence    uint16 // 某一个时刻，可以总共生产 256 个序号（2^8），但是使用 uint16 位保存，是因为可能存在时间回拨现象
 	machineID   uint16 // 机器号
+	layout      Layout // ID 各部分的位布局，见 Layout
+
+	// checkMachineID 保存 Settings.CheckMachineID，以便在每个新的时间槽重新校验机器 ID
+	// 是否仍然合法（例如续租式的 MachineID 分配器的租约是否已过期），而不只是在创建时校验一次
+	checkMachineID func(uint16) bool
+
+	rollbackPolicy ClockRollbackPolicy
+	rollbackRing   [clockRollbackRingSize]rollbackSlot
+	stats          Stats
 }
 
 // NewSonyflake returns a new Sonyflake configured with the given Settings.
 // NewSonyflake returns nil in the following cases:
-// - Settings.StartTime is ahead of the current time.
-// - Settings.MachineID returns an error.
-// - Settings.CheckMachineID returns false.
+//   - Settings.StartTime is ahead of the current time.
+//   - Settings.MachineID returns an error.
+//   - Settings.MachineID (or the default lower16BitPrivateIP) returns a value that
+//     doesn't fit in Settings.Layout.MachineBits.
+//   - Settings.CheckMachineID returns false.
+//   - Settings.Layout is non-zero and fails Layout.Validate.
 func NewSonyflake(st Settings) *Sonyflake {
 	sf := new(Sonyflake)
 	sf.mutex = new(sync.Mutex)
-	sf.sequence = uint16(1<<BitLenSequence - 1)
+
+	sf.layout = st.Layout
+	if (sf.layout == Layout{}) {
+		sf.layout = LayoutSonyflake
+	}
+	if err := sf.layout.Validate(); err != nil {
+		return nil
+	}
+	sf.sequence = sf.layout.maxSequence()
 
 	if st.StartTime.After(time.Now()) {
 		return nil
 	}
 	if st.StartTime.IsZero() {
-		sf.startTime = toSonyflakeTime(time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC))
+		sf.startTime = sf.layout.toLayoutTime(time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC))
 	} else {
-		sf.startTime = toSonyflakeTime(st.StartTime)
+		sf.startTime = sf.layout.toLayoutTime(st.StartTime)
 	}
 
 	var err error
@@ -83,71 +177,182 @@ func NewSonyflake(st Settings) *Sonyflake {
 	} else {
 		sf.machineID, err = st.MachineID()
 	}
-	if err != nil || (st.CheckMachineID != nil && !st.CheckMachineID(sf.machineID)) {
+	if err != nil {
+		return nil
+	}
+	// 机器 ID 必须落在当前 layout 的 MachineBits 范围内，否则高位会溢出进 sequence/time
+	// 字段，导致生成的 ID 静默错误（而不是直接报错）。用 uint32 比较，避免 MachineBits == 16
+	// 时 1<<16 溢出 uint16 归零。
+	if uint32(sf.machineID) >= uint32(1)<<sf.layout.MachineBits {
+		return nil
+	}
+	sf.checkMachineID = st.CheckMachineID
+	if sf.checkMachineID != nil && !sf.checkMachineID(sf.machineID) {
 		return nil
 	}
 
+	sf.rollbackPolicy = st.ClockRollbackPolicy
+	if sf.rollbackPolicy.SmallRollbackUnits == 0 {
+		sf.rollbackPolicy.SmallRollbackUnits = 1
+	}
+	if sf.rollbackPolicy.LargeRollbackUnits == 0 {
+		sf.rollbackPolicy.LargeRollbackUnits = 500
+	}
+
 	return sf
 }
 
 // NextID generates a next unique ID.
 // After the Sonyflake time overflows, NextID returns an error.
+// If Settings.CheckMachineID was set, NextID also re-runs it at the start of every
+// new time slot (roughly once per TimeUnit) and fails with an error if it now
+// returns false, so a machine id whose lease silently expired (e.g. a machineid
+// Allocator losing its connection to the coordination backend) is caught instead of
+// continuing to mint IDs under a machine id another instance may have reclaimed.
 func (sf *Sonyflake) NextID() (uint64, error) {
-	const maskSequence = uint16(1<<BitLenSequence - 1)
+	maskSequence := sf.layout.maxSequence()
 	// 上全局锁
 	sf.mutex.Lock()
 	defer sf.mutex.Unlock()
 	// 获取当前时间到 startTime 的时间序列
-	current := currentElapsedTime(sf.startTime)
-	if sf.elapsedTime < current {
-		// sf.elapsedTime < current 没有出现时间回拨现象，处于新的时间轮次，需要将 sequence 重新置为 0
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	switch {
+	case sf.elapsedTime < current:
+		// 没有出现时间回拨现象，处于新的时间轮次，需要将 sequence 重新置为 0
 		sf.elapsedTime = current
 		sf.sequence = 0
-	} else { // sf.elapsedTime >= current
-		// 下面的逻辑意味着：
-		// 1. 如果时间没有回拨，也就是当前时刻下 256 个 ID 分配完了，那么就等待一个时间单位 10ms，然后下一个时刻 sf.elapsedTim+1 开始从 sequence = 0 开始继续递增分配
-		// 2. 如果时间回拨了，那么会在这个大于回拨时间的 sf.elapsedTime 继续分配（不是在回拨时间 current 时刻下）这个 256 个 ID
-		// 在这个过程中，如果 ID 被分配完了，那么就 sleep 到 sf.elapsedTime+1 时刻，然后继续从 0 开始分配 sequence
-		// 如果 ID 没有分配完，但是时间又恢复正常，即解决了时间回拨问题，那么就会按照正常逻辑，sf.elapsedTime = current，然后继续从 0 开始分配 sequence
+		if sf.checkMachineID != nil && !sf.checkMachineID(sf.machineID) {
+			return 0, errors.New("sonyflake: machine id is no longer valid")
+		}
+	case sf.elapsedTime == current:
+		// 当前时刻下 256 个 ID 分配完了，那么就等待一个时间单位 10ms，然后下一个时刻 sf.elapsedTime+1 开始从 sequence = 0 继续递增分配
 		sf.sequence = (sf.sequence + 1) & maskSequence
-		// sf.sequence == 0 有两种情况
-		// 情况1：sf.elapsedTime == current && sf.sequence == 0，说明此 sf.elapsedTime 时刻的 256 个序号被消耗完毕了，因此等待一个时间单位（sf.elapsedTime++）
-		// 情况2：sf.elapsedTime > current && sf.sequence == 0，说明出现了时间回拨现象，因此等待，直到机器时间等于 sf.elapsedTime + 1
 		if sf.sequence == 0 {
-			sf.elapsedTime++ // 两种情况都会进行 sf.elapsedTime++，这是最关键的
-			overtime := sf.elapsedTime - current
-			// 为了避免时间回拨，sleep 这些时间
+			sf.elapsedTime++
+			sf.stats.Waits++
 			// NOTE：此时锁没有释放，当前协程 sleep 期间，其余调用 Sonyflake.NextID 的方法也会阻塞于锁，相当于继续等待
-			time.Sleep(sleepTime((overtime)))
+			time.Sleep(sf.layout.sleepDuration(sf.elapsedTime - current))
 		}
+	default:
+		// sf.elapsedTime > current：出现了时间回拨现象，按 ClockRollbackPolicy 分级处理。
+		// handleClockRollback builds and returns the ID itself, because the sleep-and-retry
+		// path below releases sf.mutex, and by the time it relocks, sf.elapsedTime/sf.sequence
+		// may have been advanced further by another goroutine that ran in the meantime.
+		return sf.handleClockRollback(current)
 	}
 
+	sf.recordSequence()
+
 	return sf.toID()
 }
 
-const sonyflakeTimeUnit = 1e7 // nsec, i.e. 10 msec
+// handleClockRollback is invoked by NextID with sf.mutex held, whenever the wall
+// clock has moved backwards past sf.elapsedTime. current is the freshly observed
+// (rolled-back) elapsed time. It returns the finished ID directly, rather than
+// mutating sf.elapsedTime/sf.sequence for the caller to read afterwards, since the
+// sleep-and-retry path below must release sf.mutex.
+func (sf *Sonyflake) handleClockRollback(current int64) (uint64, error) {
+	maskSequence := sf.layout.maxSequence()
 
-func toSonyflakeTime(t time.Time) int64 {
-	return t.UTC().UnixNano() / sonyflakeTimeUnit
-}
+	sf.stats.Rollbacks++
+	delta := sf.elapsedTime - current
+	policy := sf.rollbackPolicy
+
+	if delta <= policy.SmallRollbackUnits {
+		// 小幅回拨：优先从内存中的环形缓冲区恢复，而不是 sleep
+		if stored, ok := sf.ringLookup(current); ok {
+			if stored != maskSequence {
+				sf.elapsedTime = current
+				sf.sequence = stored + 1
+				sf.recordSequence()
+				return sf.toID()
+			}
+			// 该时间槽的 256 个序号已经用完，询问调用方是否能跨实例failover。
+			// OnSequenceExhausted 在释放 sf.mutex 之后调用：sync.Mutex 不可重入，
+			// 如果钩子回调本实例的方法（例如 Stats 或 NextID），持锁调用会直接死锁。
+			if policy.OnSequenceExhausted != nil {
+				sf.stats.Exhaustions++
+				sf.mutex.Unlock()
+				seq, err := policy.OnSequenceExhausted(current)
+				sf.mutex.Lock()
+				if err != nil {
+					return 0, err
+				}
+				sf.elapsedTime = current
+				sf.sequence = seq
+				sf.recordSequence()
+				return sf.toID()
+			}
+			// 没有 failover 钩子，退化为下面的 sleep-and-retry
+		} else {
+			// 这个时间槽之前没有被使用过，可以安全地从 sequence 0 开始分配
+			sf.elapsedTime = current
+			sf.sequence = 0
+			sf.recordSequence()
+			return sf.toID()
+		}
+	}
+
+	// OnClockRollback is invoked with sf.mutex released, for the same reason as
+	// OnSequenceExhausted above: sync.Mutex isn't reentrant, so a hook that calls back
+	// into this instance (e.g. Stats or NextID) would otherwise deadlock.
+	if delta > policy.LargeRollbackUnits && policy.OnClockRollback != nil {
+		sf.mutex.Unlock()
+		err := policy.OnClockRollback(time.Duration(delta * int64(sf.layout.TimeUnit)))
+		sf.mutex.Lock()
+		if err != nil {
+			return 0, err
+		}
+	}
 
-func currentElapsedTime(startTime int64) int64 {
-	return toSonyflakeTime(time.Now()) - startTime
+	// 中等幅度回拨（或大幅回拨但钩子未返回错误）：retain 原来的 sleep-and-retry 行为，
+	// 但 sleep 期间释放锁，以便环形缓冲区恢复路径可以继续服务其他调用方。
+	// 在释放锁之前，先把这次预留的 (elapsedTime, sequence) 记入环形缓冲区——这样其他
+	// 协程在我们 sleep 期间走小幅回拨恢复路径时，会看到这个槽已被占用并从 stored+1 续上，
+	// 而不是重新分配同一对 (elapsedTime, sequence)。醒来后也只使用这里快照的值来构造
+	// ID，不再读取 sf 的字段，因为它们届时可能已经被其他协程推进过了。
+	sf.sequence = (sf.sequence + 1) & maskSequence
+	if sf.sequence == 0 {
+		sf.elapsedTime++
+	}
+	reservedElapsedTime, reservedSequence := sf.elapsedTime, sf.sequence
+	sf.recordSequence()
+	wait := sf.layout.sleepDuration(reservedElapsedTime - current)
+	sf.stats.Waits++
+	sf.mutex.Unlock()
+	time.Sleep(wait)
+	sf.mutex.Lock()
+	return sf.layout.toID(reservedElapsedTime, reservedSequence, sf.machineID)
 }
 
-func sleepTime(overtime int64) time.Duration {
-	return time.Duration(overtime*sonyflakeTimeUnit) -
-		time.Duration(time.Now().UTC().UnixNano()%sonyflakeTimeUnit)
+// recordSequence remembers the sequence number just issued for sf.elapsedTime in the
+// ring buffer, so a future small clock rollback into this slot can resume from it.
+func (sf *Sonyflake) recordSequence() {
+	sf.rollbackRing[sf.elapsedTime%clockRollbackRingSize] = rollbackSlot{
+		elapsedTime: sf.elapsedTime,
+		sequence:    sf.sequence,
+	}
 }
 
-func (sf *Sonyflake) toID() (uint64, error) {
-	if sf.elapsedTime >= 1<<BitLenTime {
-		return 0, errors.New("over the time limit")
+// ringLookup returns the highest sequence number previously issued for elapsedTime,
+// if that slot is still present in the ring buffer.
+func (sf *Sonyflake) ringLookup(elapsedTime int64) (uint16, bool) {
+	slot := sf.rollbackRing[elapsedTime%clockRollbackRingSize]
+	if slot.elapsedTime != elapsedTime {
+		return 0, false
 	}
+	return slot.sequence, true
+}
+
+// Stats returns a snapshot of the clock-rollback handling counters.
+func (sf *Sonyflake) Stats() Stats {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	return sf.stats
+}
 
-	return uint64(sf.elapsedTime)<<(BitLenSequence+BitLenMachineID) |
-		uint64(sf.sequence)<<BitLenMachineID |
-		uint64(sf.machineID), nil
+func (sf *Sonyflake) toID() (uint64, error) {
+	return sf.layout.toID(sf.elapsedTime, sf.sequence, sf.machineID)
 }
 
 func privateIPv4() (net.IP, error) {
@@ -185,40 +390,27 @@ func lower16BitPrivateIP() (uint16, error) {
 
 // ElapsedTime returns the elapsed time when the given Sonyflake ID was generated.
 // 得到此 ID 距 startTime 已经有多少时间了
+//
+// ElapsedTime, SequenceNumber, MachineID and Decompose assume the ID was generated
+// with LayoutSonyflake; for IDs generated with a different Layout (see Settings.Layout),
+// call the equivalent method on that Layout value instead.
 func ElapsedTime(id uint64) time.Duration {
-	return time.Duration(elapsedTime(id) * sonyflakeTimeUnit)
-}
-
-// 将 ID 向右移动 8 + 16 位，获取得到 ID 的前 40 位（首位一直为 0），因此相当于获取 39 位的时间戳数值
-func elapsedTime(id uint64) uint64 {
-	return id >> (BitLenSequence + BitLenMachineID)
+	return LayoutSonyflake.ElapsedTime(id)
 }
 
 // SequenceNumber returns the sequence number of a Sonyflake ID.
 // 返回序列 ID
 func SequenceNumber(id uint64) uint64 {
-	const maskSequence = uint64((1<<BitLenSequence - 1) << BitLenMachineID)
-	return id & maskSequence >> BitLenMachineID
+	return LayoutSonyflake.SequenceNumber(id)
 }
 
 // MachineID returns the machine ID of a Sonyflake ID.
 // 返回机器 ID
 func MachineID(id uint64) uint64 {
-	const maskMachineID = uint64(1<<BitLenMachineID - 1)
-	return id & maskMachineID
+	return LayoutSonyflake.MachineID(id)
 }
 
 // Decompose returns a set of Sonyflake ID parts.
 func Decompose(id uint64) map[string]uint64 {
-	msb := id >> 63
-	time := elapsedTime(id)
-	sequence := SequenceNumber(id)
-	machineID := MachineID(id)
-	return map[string]uint64{
-		"id":         id,        // 整个 ID
-		"msb":        msb,       // 首位
-		"time":       time,      // 时间戳
-		"sequence":   sequence,  // 序列号
-		"machine-id": machineID, // 机器 ID
-	}
+	return LayoutSonyflake.Decompose(id)
 }
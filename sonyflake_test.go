@@ -0,0 +1,201 @@
+package sonyflake
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestSonyflake(t *testing.T, policy ClockRollbackPolicy) *Sonyflake {
+	t.Helper()
+	sf := NewSonyflake(Settings{
+		MachineID:           func() (uint16, error) { return 1, nil },
+		ClockRollbackPolicy: policy,
+	})
+	if sf == nil {
+		t.Fatal("NewSonyflake() = nil")
+	}
+	return sf
+}
+
+func TestNextID_SmallRollbackVirginSlotRecoversWithoutSleep(t *testing.T) {
+	sf := newTestSonyflake(t, ClockRollbackPolicy{})
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current + 1 // pretend the clock has rolled back by 1 unit
+	sf.sequence = 42
+	sf.mutex.Unlock()
+
+	start := time.Now()
+	id, err := sf.NextID()
+	if err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("small rollback recovery took %v, want no sleep", elapsed)
+	}
+	if got := sf.layout.elapsedTimeUnits(id); got != current {
+		t.Errorf("elapsedTimeUnits(id) = %d, want %d", got, current)
+	}
+	if got := sf.layout.SequenceNumber(id); got != 0 {
+		t.Errorf("SequenceNumber(id) = %d, want 0 (slot not previously used)", got)
+	}
+	if got := sf.Stats().Rollbacks; got != 1 {
+		t.Errorf("Stats().Rollbacks = %d, want 1", got)
+	}
+}
+
+func TestNextID_SmallRollbackResumesFromRingBuffer(t *testing.T) {
+	sf := newTestSonyflake(t, ClockRollbackPolicy{})
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current
+	sf.sequence = 5
+	sf.recordSequence() // seed the ring buffer, as a prior NextID call would have
+	sf.elapsedTime = current + 1
+	sf.mutex.Unlock()
+
+	id, err := sf.NextID()
+	if err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if got := sf.layout.elapsedTimeUnits(id); got != current {
+		t.Errorf("elapsedTimeUnits(id) = %d, want %d", got, current)
+	}
+	if got := sf.layout.SequenceNumber(id); got != 6 {
+		t.Errorf("SequenceNumber(id) = %d, want 6 (resumed from ring buffer)", got)
+	}
+}
+
+func TestNextID_SmallRollbackExhaustedRingSlotConsultsHook(t *testing.T) {
+	current := int64(0)
+	var hookCalled bool
+	sf := newTestSonyflake(t, ClockRollbackPolicy{
+		OnSequenceExhausted: func(elapsedTime int64) (uint16, error) {
+			hookCalled = true
+			return 7, nil
+		},
+	})
+	current = sf.layout.currentElapsedTime(sf.startTime)
+
+	sf.mutex.Lock()
+	sf.elapsedTime = current
+	sf.sequence = sf.layout.maxSequence() // the slot's sequence space is exhausted
+	sf.recordSequence()
+	sf.elapsedTime = current + 1
+	sf.mutex.Unlock()
+
+	id, err := sf.NextID()
+	if err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if !hookCalled {
+		t.Fatal("OnSequenceExhausted was not invoked for an exhausted ring slot")
+	}
+	if got := sf.layout.SequenceNumber(id); got != 7 {
+		t.Errorf("SequenceNumber(id) = %d, want 7 (from OnSequenceExhausted)", got)
+	}
+	if got := sf.Stats().Exhaustions; got != 1 {
+		t.Errorf("Stats().Exhaustions = %d, want 1", got)
+	}
+}
+
+func TestNextID_SmallRollbackExhaustedHookReentersWithoutDeadlock(t *testing.T) {
+	sf := newTestSonyflake(t, ClockRollbackPolicy{})
+	sf.rollbackPolicy.OnSequenceExhausted = func(elapsedTime int64) (uint16, error) {
+		// A hook that calls back into the instance must not deadlock, since NextID
+		// releases sf.mutex before invoking it.
+		sf.Stats()
+		return 0, nil
+	}
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current
+	sf.sequence = sf.layout.maxSequence()
+	sf.recordSequence()
+	sf.elapsedTime = current + 1
+	sf.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sf.NextID()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("NextID deadlocked when OnSequenceExhausted called back into the instance")
+	}
+}
+
+func TestNextID_LargeRollbackInvokesOnClockRollback(t *testing.T) {
+	var gotDelta time.Duration
+	sf := newTestSonyflake(t, ClockRollbackPolicy{
+		LargeRollbackUnits: 2,
+		OnClockRollback: func(delta time.Duration) error {
+			gotDelta = delta
+			return nil
+		},
+	})
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current + 10 // well beyond LargeRollbackUnits
+	sf.sequence = 0
+	sf.mutex.Unlock()
+
+	if _, err := sf.NextID(); err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if gotDelta <= 0 {
+		t.Fatalf("OnClockRollback delta = %v, want > 0", gotDelta)
+	}
+}
+
+func TestNextID_LargeRollbackOnClockRollbackErrorPropagates(t *testing.T) {
+	wantErr := errors.New("instance unhealthy")
+	sf := newTestSonyflake(t, ClockRollbackPolicy{
+		LargeRollbackUnits: 2,
+		OnClockRollback: func(delta time.Duration) error {
+			return wantErr
+		},
+	})
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current + 10
+	sf.sequence = 0
+	sf.mutex.Unlock()
+
+	if _, err := sf.NextID(); !errors.Is(err, wantErr) {
+		t.Fatalf("NextID() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestNextID_LargeRollbackHookReentersWithoutDeadlock(t *testing.T) {
+	sf := newTestSonyflake(t, ClockRollbackPolicy{LargeRollbackUnits: 2})
+	sf.rollbackPolicy.OnClockRollback = func(delta time.Duration) error {
+		sf.Stats()
+		return nil
+	}
+
+	current := sf.layout.currentElapsedTime(sf.startTime)
+	sf.mutex.Lock()
+	sf.elapsedTime = current + 10
+	sf.sequence = 0
+	sf.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sf.NextID()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("NextID deadlocked when OnClockRollback called back into the instance")
+	}
+}